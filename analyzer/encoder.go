@@ -0,0 +1,64 @@
+package analyzer
+
+import (
+	"fmt"
+
+	"github.com/moutend/ywca/audio"
+)
+
+// Encoder wraps an audio.AudioEncoder, running a Meter over every frame as
+// it passes through and printing (and optionally tagging) the result on
+// Close.
+type Encoder struct {
+	next     audio.AudioEncoder
+	file     seekWriter
+	filename string
+	tag      bool
+	meter    *Meter
+}
+
+// seekWriter is the subset of *os.File WrapEncoder needs to rewrite the
+// tag page reserved in the output once capture finishes, regardless of
+// whether the chosen AudioEncoder itself buffers or seeks.
+type seekWriter interface {
+	Write(p []byte) (int, error)
+	Seek(offset int64, whence int) (int64, error)
+}
+
+// WrapEncoder returns an AudioEncoder that analyzes the stream written to
+// next. file is the same file next ultimately writes to; filename is that
+// file's name, used at Close to pick the tagging mechanism (if any) that
+// actually fits the container. file is used only for tagging, after next
+// has finished writing its own data.
+func WrapEncoder(next audio.AudioEncoder, file seekWriter, filename string, tag bool) *Encoder {
+	return &Encoder{next: next, file: file, filename: filename, tag: tag}
+}
+
+func (e *Encoder) WriteHeader(format audio.Format) error {
+	e.meter = NewMeter(format)
+	return e.next.WriteHeader(format)
+}
+
+func (e *Encoder) WriteFrames(data []byte) error {
+	e.meter.Write(data)
+	return e.next.WriteFrames(data)
+}
+
+func (e *Encoder) Close() error {
+	if err := e.next.Close(); err != nil {
+		return err
+	}
+
+	result := e.meter.Finalize()
+	fmt.Println("--------")
+	fmt.Printf("Integrated loudness: %.1f LUFS\n", result.IntegratedLUFS)
+	fmt.Printf("True peak: %.1f dBTP\n", result.TruePeakDBTP)
+	fmt.Printf("ReplayGain 2.0 track gain: %.2f dB\n", result.ReplayGainDB)
+	fmt.Printf("ReplayGain 2.0 track peak: %.6f\n", result.ReplayGainPeak)
+	fmt.Println("--------")
+
+	if !e.tag {
+		return nil
+	}
+	return writeReplayGainTag(e.file, e.filename, result)
+}
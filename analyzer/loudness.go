@@ -0,0 +1,270 @@
+// Package analyzer computes EBU R128 integrated loudness, true peak and
+// ReplayGain 2.0 track gain/peak over a captured PCM stream as it is
+// written, so --analyze can report levels without a second pass over the
+// output file.
+package analyzer
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/moutend/ywca/audio"
+)
+
+// blockOverlap follows ITU-R BS.1770-4 / EBU R128: 400ms gating blocks,
+// updated every 100ms (75% overlap). subBlocksPerBlock is the number of
+// 100ms sub-blocks a gating block spans, derived from blockOverlap rather
+// than hard-coded so the two can't drift apart.
+const (
+	blockOverlap      = 0.75
+	subBlocksPerBlock = int(1 / (1 - blockOverlap))
+
+	absoluteGateLUFS = -70.0
+	relativeGateLU   = -10.0
+)
+
+// biquad is a direct-form II transposed biquad section, used for the two
+// cascaded K-weighting filter stages.
+type biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+	z1, z2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x + f.z2 - f.a1*y
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// newKWeightingStages returns the two cascaded biquads of the ITU-R
+// BS.1770-4 K-weighting pre-filter, using the standard coefficients
+// specified for a 48kHz sample rate. This is a common simplification for
+// other sample rates too; BS.1770 strictly requires recalculating the
+// coefficients per rate, which is out of scope for this tool.
+func newKWeightingStages() [2]*biquad {
+	return [2]*biquad{
+		{ // stage 1: shelving filter modeling head diffraction
+			b0: 1.53512485958697,
+			b1: -2.69169618940638,
+			b2: 1.19839281085285,
+			a1: -1.69065929318241,
+			a2: 0.73248077421585,
+		},
+		{ // stage 2: RLB-weighting high-pass
+			b0: 1.0,
+			b1: -2.0,
+			b2: 1.0,
+			a1: -1.99004745483398,
+			a2: 0.99007225036621,
+		},
+	}
+}
+
+// channelMeter carries the K-weighting filter state for one channel, plus a
+// ring of its last subBlocksPerBlock 100ms sub-block mean-squares. Gating
+// blocks are 75%-overlapping, so each new 100ms of audio contributes to four
+// consecutive blocks; keeping the per-sub-block energies around and summing
+// the last four, rather than accumulating and resetting once per block, is
+// what makes that overlap work in a single streaming pass.
+type channelMeter struct {
+	stages      [2]*biquad
+	sumSquares  float64
+	sampleCount int
+
+	subBlocks [subBlocksPerBlock]float64
+	numFilled int // sub-blocks written so far, capped at subBlocksPerBlock
+	nextSlot  int // ring write cursor
+}
+
+func newChannelMeter() *channelMeter {
+	return &channelMeter{stages: newKWeightingStages()}
+}
+
+func (c *channelMeter) add(sample float64) {
+	y := c.stages[0].process(sample)
+	y = c.stages[1].process(y)
+	c.sumSquares += y * y
+	c.sampleCount++
+}
+
+// closeSubBlock folds the current 100ms accumulator into the ring and resets
+// it for the next sub-block.
+func (c *channelMeter) closeSubBlock() {
+	var ms float64
+	if c.sampleCount > 0 {
+		ms = c.sumSquares / float64(c.sampleCount)
+	}
+	c.subBlocks[c.nextSlot] = ms
+	c.nextSlot = (c.nextSlot + 1) % subBlocksPerBlock
+	if c.numFilled < subBlocksPerBlock {
+		c.numFilled++
+	}
+	c.sumSquares = 0
+	c.sampleCount = 0
+}
+
+// meanSquare averages whatever sub-block energies are currently in the
+// ring: a full 400ms window once numFilled reaches subBlocksPerBlock, or
+// less at stream start or a short trailing remainder.
+func (c *channelMeter) meanSquare() float64 {
+	if c.numFilled == 0 {
+		return 0
+	}
+	var sum float64
+	for i := 0; i < c.numFilled; i++ {
+		sum += c.subBlocks[i]
+	}
+	return sum / float64(c.numFilled)
+}
+
+// Meter computes integrated loudness, true peak and ReplayGain 2.0 track
+// gain/peak from a stream of 16-bit PCM frames, fed incrementally via
+// Write.
+type Meter struct {
+	format   audio.Format
+	channels []*channelMeter
+	hopSize  int // samples per channel per 100ms sub-block
+	pos      int // samples written toward the current sub-block since its start
+
+	truePeak *truePeakDetector
+
+	blockLoudnessLUFS []float64
+}
+
+// NewMeter returns a Meter for a stream in the given format.
+func NewMeter(format audio.Format) *Meter {
+	m := &Meter{
+		format:   format,
+		channels: make([]*channelMeter, format.Channels),
+		hopSize:  int(float64(format.SamplesPerSec) * 0.4 * (1 - blockOverlap)),
+		truePeak: newTruePeakDetector(int(format.Channels)),
+	}
+	for i := range m.channels {
+		m.channels[i] = newChannelMeter()
+	}
+	return m
+}
+
+// Write feeds one block of interleaved 16-bit PCM frames into the meter.
+func (m *Meter) Write(frames []byte) {
+	channels := int(m.format.Channels)
+	frameSize := 2 * channels
+
+	for i := 0; i+frameSize <= len(frames); i += frameSize {
+		for ch := 0; ch < channels; ch++ {
+			off := i + ch*2
+			sample := float64(int16(binary.LittleEndian.Uint16(frames[off:]))) / 32768.0
+			m.channels[ch].add(sample)
+		}
+		m.truePeak.add(frames[i : i+frameSize])
+
+		m.pos++
+		if m.pos >= m.hopSize {
+			m.closeSubBlock()
+			m.pos = 0
+		}
+	}
+}
+
+// closeSubBlock folds the just-finished 100ms into each channel's ring of
+// sub-block energies and, once a full 400ms of history has accumulated,
+// records one more gating block from the last four.
+func (m *Meter) closeSubBlock() {
+	for _, c := range m.channels {
+		c.closeSubBlock()
+	}
+	if m.channels[0].numFilled < subBlocksPerBlock {
+		return
+	}
+	m.recordBlock()
+}
+
+// recordBlock computes one gating block's loudness from the channels'
+// current sub-block rings.
+func (m *Meter) recordBlock() {
+	var weightedSum float64
+	for _, c := range m.channels {
+		weightedSum += c.meanSquare() // channel weight G_i = 1.0 for L/R/mono
+	}
+	if weightedSum <= 0 {
+		m.blockLoudnessLUFS = append(m.blockLoudnessLUFS, math.Inf(-1))
+		return
+	}
+	lufs := -0.691 + 10*math.Log10(weightedSum)
+	m.blockLoudnessLUFS = append(m.blockLoudnessLUFS, lufs)
+}
+
+// Result is the outcome of a completed analysis pass.
+type Result struct {
+	IntegratedLUFS float64
+	TruePeakDBTP   float64
+	ReplayGainDB   float64
+	ReplayGainPeak float64 // linear, 0..1+
+}
+
+// referenceLUFS is the ReplayGain 2.0 reference loudness: tracks are scaled
+// so their integrated loudness reads -18 LUFS.
+const referenceLUFS = -18.0
+
+// Finalize flushes any partial trailing sub-block and computes the gated
+// integrated loudness, true peak and ReplayGain values.
+func (m *Meter) Finalize() Result {
+	if m.pos > 0 {
+		m.closeSubBlock()
+	}
+	// A capture shorter than 400ms never reaches subBlocksPerBlock, so
+	// closeSubBlock never records a block for it; report one anyway, from
+	// whatever sub-blocks it did accumulate, rather than an empty result.
+	if len(m.blockLoudnessLUFS) == 0 && m.channels[0].numFilled > 0 {
+		m.recordBlock()
+	}
+
+	integrated := gatedMean(m.blockLoudnessLUFS)
+	peakDBTP := m.truePeak.dBTP()
+
+	return Result{
+		IntegratedLUFS: integrated,
+		TruePeakDBTP:   peakDBTP,
+		ReplayGainDB:   referenceLUFS - integrated,
+		ReplayGainPeak: m.truePeak.peak(),
+	}
+}
+
+// gatedMean applies the EBU R128 two-pass gating: blocks quieter than the
+// absolute gate are discarded outright, then blocks quieter than 10 LU
+// below the mean of what's left are discarded too.
+func gatedMean(blocks []float64) float64 {
+	var passOne []float64
+	for _, lufs := range blocks {
+		if lufs > absoluteGateLUFS {
+			passOne = append(passOne, lufs)
+		}
+	}
+	if len(passOne) == 0 {
+		return math.Inf(-1)
+	}
+
+	mean := meanLUFS(passOne)
+
+	var passTwo []float64
+	for _, lufs := range passOne {
+		if lufs > mean+relativeGateLU {
+			passTwo = append(passTwo, lufs)
+		}
+	}
+	if len(passTwo) == 0 {
+		return mean
+	}
+	return meanLUFS(passTwo)
+}
+
+// meanLUFS averages LUFS values in the power domain, not the log domain.
+func meanLUFS(blocks []float64) float64 {
+	var sum float64
+	for _, lufs := range blocks {
+		sum += math.Pow(10, (lufs+0.691)/10)
+	}
+	return -0.691 + 10*math.Log10(sum/float64(len(blocks)))
+}
@@ -0,0 +1,44 @@
+package analyzer
+
+import (
+	"strings"
+
+	"github.com/moutend/ywca/audio"
+)
+
+// writeReplayGainTag records the computed ReplayGain 2.0 values in w, using
+// whichever tagging mechanism actually fits the container named by
+// filename. WAV has no room for extra bytes the RIFF header doesn't
+// account for, so it is skipped entirely. Ogg/Opus gets the values written
+// into the comment page OggOpusEncoder already reserved in its header
+// (audio.RewriteOpusReplayGainPage). FLAC is skipped too: real players read
+// ReplayGain from a Vorbis-comment METADATA_BLOCK, which FLACEncoder would
+// need to reserve through libFLAC's metadata API before the stream starts,
+// and nothing here does that yet, so there is no tagging mechanism for FLAC
+// to use honestly.
+func writeReplayGainTag(w seekWriter, filename string, result Result) error {
+	switch strings.ToLower(extensionOf(filename)) {
+	case ".opus", ".ogg":
+		return writeOpusReplayGainTag(w, result)
+	default:
+		return nil
+	}
+}
+
+func extensionOf(filename string) string {
+	i := strings.LastIndexByte(filename, '.')
+	if i < 0 {
+		return ""
+	}
+	return filename[i:]
+}
+
+// writeOpusReplayGainTag re-emits the comment page reserved by
+// OggOpusEncoder's OpusTags packet with the real ReplayGain values and a
+// freshly computed checksum, rather than appending anything after the
+// stream's EOS page (which an Opus decoder would never read) or patching
+// just the comment bytes in place (which would leave the page's checksum
+// valid only for the placeholder content it was computed over).
+func writeOpusReplayGainTag(w seekWriter, result Result) error {
+	return audio.RewriteOpusReplayGainPage(w, result.ReplayGainDB, result.ReplayGainPeak)
+}
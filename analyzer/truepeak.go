@@ -0,0 +1,98 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// truePeakOversample is the oversampling factor ITU-R BS.1770-4 recommends
+// for estimating inter-sample peaks (true peak) from a discrete PCM
+// stream.
+const truePeakOversample = 4
+
+// truePeakTaps is the half-length, per phase, of the polyphase
+// windowed-sinc interpolator used to oversample before peak-picking.
+const truePeakTaps = 8
+
+// truePeakDetector estimates the true (inter-sample) peak of a PCM stream
+// by upsampling 4x with a polyphase windowed-sinc filter and tracking the
+// largest magnitude sample seen at the higher rate.
+type truePeakDetector struct {
+	channels int
+	taps     [truePeakOversample][]float64
+	history  [][]float64 // per channel, last 2*truePeakTaps input samples
+	maxAbs   float64
+}
+
+func newTruePeakDetector(channels int) *truePeakDetector {
+	d := &truePeakDetector{
+		channels: channels,
+		history:  make([][]float64, channels),
+	}
+	for ch := range d.history {
+		d.history[ch] = make([]float64, 2*truePeakTaps)
+	}
+	for phase := 0; phase < truePeakOversample; phase++ {
+		sub := make([]float64, 2*truePeakTaps)
+		for k := -truePeakTaps; k < truePeakTaps; k++ {
+			t := float64(k) - float64(phase)/float64(truePeakOversample)
+			sub[k+truePeakTaps] = sinc(t) * blackman(t, truePeakTaps)
+		}
+		d.taps[phase] = sub
+	}
+	return d
+}
+
+// add feeds one frame (all channels) of 16-bit PCM into the detector.
+func (d *truePeakDetector) add(frame []byte) {
+	for ch := 0; ch < d.channels; ch++ {
+		sample := float64(int16(binary.LittleEndian.Uint16(frame[ch*2:]))) / 32768.0
+
+		h := d.history[ch]
+		copy(h, h[1:])
+		h[len(h)-1] = sample
+
+		for phase := 0; phase < truePeakOversample; phase++ {
+			y := convolve1D(d.taps[phase], h)
+			if abs := math.Abs(y); abs > d.maxAbs {
+				d.maxAbs = abs
+			}
+		}
+	}
+}
+
+func convolve1D(taps, window []float64) float64 {
+	var sum float64
+	for i, t := range taps {
+		sum += t * window[i]
+	}
+	return sum
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	px := math.Pi * x
+	return math.Sin(px) / px
+}
+
+// blackman evaluates a Blackman window centered on 0 over [-halfWidth, halfWidth).
+func blackman(t float64, halfWidth int) float64 {
+	n := (t/float64(halfWidth))*0.5 + 0.5
+	return 0.42 - 0.5*math.Cos(2*math.Pi*n) + 0.08*math.Cos(4*math.Pi*n)
+}
+
+// peak returns the estimated true peak as a linear amplitude (0..1+).
+func (d *truePeakDetector) peak() float64 {
+	return d.maxAbs
+}
+
+// dBTP returns the estimated true peak in dBTP (dB relative to full
+// scale), the unit ITU-R BS.1770-4 reports it in.
+func (d *truePeakDetector) dBTP() float64 {
+	if d.maxAbs <= 0 {
+		return math.Inf(-1)
+	}
+	return 20 * math.Log10(d.maxAbs)
+}
@@ -0,0 +1,59 @@
+// Package audio provides pluggable output encoders for captured PCM audio,
+// so callers can stream a capture straight to disk instead of buffering the
+// whole recording in memory.
+package audio
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format describes the PCM layout of the frames passed to WriteFrames. It is
+// populated from the WAVEFORMATEX negotiated with WASAPI.
+type Format struct {
+	Channels       uint16
+	SamplesPerSec  uint32
+	BitsPerSample  uint16
+	BlockAlign     uint16
+	AvgBytesPerSec uint32
+}
+
+// AudioEncoder consumes a PCM stream and serializes it into a specific
+// container/codec. WriteHeader is called exactly once before any calls to
+// WriteFrames, and Close is called exactly once after the last WriteFrames
+// call to flush any buffered state and finalize the output.
+type AudioEncoder interface {
+	WriteHeader(format Format) error
+	WriteFrames(data []byte) error
+	Close() error
+}
+
+// NewEncoder selects an AudioEncoder based on the output filename's
+// extension. w is typically the *os.File the caller just created; encoders
+// that need to patch a header after the fact (WAV) require it to also
+// implement io.Seeker.
+func NewEncoder(filename string, w io.Writer) (AudioEncoder, error) {
+	switch strings.ToLower(extensionOf(filename)) {
+	case ".wav":
+		ws, ok := w.(io.WriteSeeker)
+		if !ok {
+			return nil, fmt.Errorf("audio: WAV encoder requires a seekable writer")
+		}
+		return NewWAVEncoder(ws), nil
+	case ".flac":
+		return NewFLACEncoder(w)
+	case ".opus", ".ogg":
+		return NewOggOpusEncoder(w)
+	default:
+		return nil, fmt.Errorf("audio: unsupported output extension %q", extensionOf(filename))
+	}
+}
+
+func extensionOf(filename string) string {
+	i := strings.LastIndexByte(filename, '.')
+	if i < 0 {
+		return ""
+	}
+	return filename[i:]
+}
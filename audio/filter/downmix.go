@@ -0,0 +1,30 @@
+package filter
+
+import "encoding/binary"
+
+// downmixCoefficient sums stereo to mono as an equal-power average. Using
+// 1/sqrt(2) here instead, as some downmix formulas do for decorrelated
+// content, amplifies center-panned or mono-compatible material (where L≈R)
+// by up to +3dB and clips, since L+R for such content is twice either
+// channel.
+const downmixCoefficient = 0.5
+
+// downmixStage sums interleaved stereo frames to mono. It only supports
+// stereo input; Pipeline only installs it when the captured format is
+// exactly 2 channels.
+type downmixStage struct{}
+
+func (downmixStage) process(frames []byte) []byte {
+	out := make([]byte, 0, len(frames)/2)
+
+	for i := 0; i+4 <= len(frames); i += 4 {
+		l := int16(binary.LittleEndian.Uint16(frames[i:]))
+		r := int16(binary.LittleEndian.Uint16(frames[i+2:]))
+		mono := clampInt16(downmixCoefficient * (float64(l) + float64(r)))
+
+		b := make([]byte, 2)
+		binary.LittleEndian.PutUint16(b, uint16(mono))
+		out = append(out, b...)
+	}
+	return out
+}
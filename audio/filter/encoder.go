@@ -0,0 +1,30 @@
+package filter
+
+import "github.com/moutend/ywca/audio"
+
+// Encoder wraps an audio.AudioEncoder, running every frame through a
+// Pipeline built from spec before handing it to the underlying encoder.
+type Encoder struct {
+	next     audio.AudioEncoder
+	spec     Spec
+	pipeline *Pipeline
+}
+
+// WrapEncoder returns an AudioEncoder that filters captured frames
+// according to spec before writing them to next.
+func WrapEncoder(next audio.AudioEncoder, spec Spec) *Encoder {
+	return &Encoder{next: next, spec: spec}
+}
+
+func (e *Encoder) WriteHeader(format audio.Format) error {
+	e.pipeline = New(e.spec, format)
+	return e.next.WriteHeader(e.pipeline.OutputFormat())
+}
+
+func (e *Encoder) WriteFrames(data []byte) error {
+	return e.next.WriteFrames(e.pipeline.Process(data))
+}
+
+func (e *Encoder) Close() error {
+	return e.next.Close()
+}
@@ -0,0 +1,93 @@
+// Package filter provides composable transforms applied to captured PCM
+// frames before they reach an audio.AudioEncoder: sample-rate conversion,
+// stereo-to-mono downmix, gain and a high-pass filter. All stages assume
+// 16-bit interleaved PCM, which is the only format this tool's capture
+// paths ever negotiate.
+package filter
+
+import "github.com/moutend/ywca/audio"
+
+// Spec selects which stages a Pipeline builds. A zero value in a field
+// disables the corresponding stage.
+type Spec struct {
+	TargetRate      uint32  // 0 = no resampling
+	TargetChannels  uint16  // 0 = no downmix
+	GainDB          float64 // 0 = no gain
+	HighPassHz      float64 // 0 = disabled
+	ResampleQuality int     // taps per side per phase; 0 = default
+}
+
+// stage transforms one block of interleaved 16-bit PCM frames.
+type stage interface {
+	process(frames []byte) []byte
+}
+
+// Pipeline runs captured frames through the stages a Spec describes, in the
+// order high-pass, downmix, resample, gain. High-pass runs first so it sees
+// the original sample rate and channel layout it was configured for;
+// gain runs last so it scales the final output level.
+type Pipeline struct {
+	stages []stage
+	out    audio.Format
+}
+
+// New builds a Pipeline for captured audio arriving in the given format.
+func New(spec Spec, in audio.Format) *Pipeline {
+	p := &Pipeline{out: in}
+
+	if spec.HighPassHz > 0 {
+		p.stages = append(p.stages, newHighPassStage(p.out.SamplesPerSec, p.out.Channels, spec.HighPassHz))
+	}
+	if spec.TargetChannels > 0 && spec.TargetChannels < p.out.Channels && p.out.Channels == 2 {
+		p.stages = append(p.stages, downmixStage{})
+		p.out = downmixFormat(p.out)
+	}
+	if spec.TargetRate > 0 && spec.TargetRate != p.out.SamplesPerSec {
+		p.stages = append(p.stages, newResampleStage(p.out.SamplesPerSec, spec.TargetRate, p.out.Channels, spec.ResampleQuality))
+		p.out = resampleFormat(p.out, spec.TargetRate)
+	}
+	if spec.GainDB != 0 {
+		p.stages = append(p.stages, newGainStage(spec.GainDB))
+	}
+
+	return p
+}
+
+// OutputFormat returns the format of the frames Process produces, after
+// every enabled stage has been applied.
+func (p *Pipeline) OutputFormat() audio.Format {
+	return p.out
+}
+
+// Process runs one block of frames through every enabled stage in order.
+func (p *Pipeline) Process(frames []byte) []byte {
+	for _, s := range p.stages {
+		frames = s.process(frames)
+	}
+	return frames
+}
+
+func downmixFormat(in audio.Format) audio.Format {
+	out := in
+	out.Channels = 1
+	out.BlockAlign = in.BitsPerSample / 8
+	out.AvgBytesPerSec = in.SamplesPerSec * uint32(out.BlockAlign)
+	return out
+}
+
+func resampleFormat(in audio.Format, targetRate uint32) audio.Format {
+	out := in
+	out.SamplesPerSec = targetRate
+	out.AvgBytesPerSec = targetRate * uint32(in.BlockAlign)
+	return out
+}
+
+func clampInt16(v float64) int16 {
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
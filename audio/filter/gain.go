@@ -0,0 +1,27 @@
+package filter
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// gainStage scales every sample by a fixed linear factor derived from a dB
+// value.
+type gainStage struct {
+	factor float64
+}
+
+func newGainStage(db float64) *gainStage {
+	return &gainStage{factor: math.Pow(10, db/20)}
+}
+
+func (s *gainStage) process(frames []byte) []byte {
+	out := make([]byte, len(frames))
+
+	for i := 0; i+2 <= len(frames); i += 2 {
+		sample := int16(binary.LittleEndian.Uint16(frames[i:]))
+		scaled := clampInt16(float64(sample) * s.factor)
+		binary.LittleEndian.PutUint16(out[i:], uint16(scaled))
+	}
+	return out
+}
@@ -0,0 +1,47 @@
+package filter
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// highPassStage is a one-pole RC high-pass filter applied independently to
+// each channel, used to attenuate DC offset and sub-audible rumble below
+// cutoffHz.
+type highPassStage struct {
+	alpha    float64
+	channels int
+	prevIn   []int16
+	prevOut  []float64
+}
+
+func newHighPassStage(sampleRate uint32, channels uint16, cutoffHz float64) *highPassStage {
+	rc := 1 / (2 * math.Pi * cutoffHz)
+	dt := 1 / float64(sampleRate)
+
+	return &highPassStage{
+		alpha:    rc / (rc + dt),
+		channels: int(channels),
+		prevIn:   make([]int16, channels),
+		prevOut:  make([]float64, channels),
+	}
+}
+
+func (s *highPassStage) process(frames []byte) []byte {
+	out := make([]byte, len(frames))
+	frameSize := 2 * s.channels
+
+	for i := 0; i+frameSize <= len(frames); i += frameSize {
+		for ch := 0; ch < s.channels; ch++ {
+			off := i + ch*2
+			sample := int16(binary.LittleEndian.Uint16(frames[off:]))
+
+			y := s.alpha * (s.prevOut[ch] + float64(sample) - float64(s.prevIn[ch]))
+			s.prevIn[ch] = sample
+			s.prevOut[ch] = y
+
+			binary.LittleEndian.PutUint16(out[off:], uint16(clampInt16(y)))
+		}
+	}
+	return out
+}
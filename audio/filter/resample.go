@@ -0,0 +1,145 @@
+package filter
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// defaultResampleQuality is the number of taps on each side of a polyphase
+// sub-filter's center when Spec.ResampleQuality is left at zero.
+const defaultResampleQuality = 16
+
+// resampleStage converts the sample rate using a polyphase FIR
+// windowed-sinc filter. It keeps a per-channel history of unconsumed input
+// samples across calls so the filter stays continuous across block
+// boundaries.
+type resampleStage struct {
+	channels int
+	l, m     int // output/input rate ratio reduced to lowest terms: rate changes by L/M
+	quality  int
+	taps     [][]float64 // taps[phase], each 2*quality long
+	buffer   [][]float64 // per channel, unconsumed input samples
+	pos      float64     // fractional read position, in input-sample units, into buffer[0]
+}
+
+func newResampleStage(sourceRate, targetRate uint32, channels uint16, quality int) *resampleStage {
+	if quality <= 0 {
+		quality = defaultResampleQuality
+	}
+
+	g := gcdUint32(sourceRate, targetRate)
+	l := int(targetRate / g)
+	m := int(sourceRate / g)
+
+	s := &resampleStage{
+		channels: int(channels),
+		l:        l,
+		m:        m,
+		quality:  quality,
+		buffer:   make([][]float64, channels),
+		pos:      float64(quality), // prime enough leading silence for the first window
+	}
+	for ch := range s.buffer {
+		s.buffer[ch] = make([]float64, quality)
+	}
+	s.taps = buildPolyphaseTaps(l, m, quality)
+	return s
+}
+
+// buildPolyphaseTaps windows a sinc low-pass filter designed for whichever
+// Nyquist frequency is lower (the source or target rate's), then slices it
+// into l polyphase sub-filters of 2*quality taps each.
+func buildPolyphaseTaps(l, m, quality int) [][]float64 {
+	// Cutoff in cycles per input sample: the input's own Nyquist (0.5), or
+	// the target's when downsampling.
+	cutoff := 0.5 * math.Min(1, float64(l)/float64(m))
+
+	taps := make([][]float64, l)
+	for phase := 0; phase < l; phase++ {
+		sub := make([]float64, 2*quality)
+		for k := -quality; k < quality; k++ {
+			t := float64(k) - float64(phase)/float64(l)
+			sub[k+quality] = sinc(2*cutoff*t) * 2 * cutoff * blackman(t, quality)
+		}
+		taps[phase] = sub
+	}
+	return taps
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	px := math.Pi * x
+	return math.Sin(px) / px
+}
+
+// blackman evaluates a Blackman window centered on 0 over [-quality, quality).
+func blackman(t float64, quality int) float64 {
+	n := (t/float64(quality))*0.5 + 0.5
+	return 0.42 - 0.5*math.Cos(2*math.Pi*n) + 0.08*math.Cos(4*math.Pi*n)
+}
+
+func gcdUint32(a, b uint32) uint32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func (s *resampleStage) process(frames []byte) []byte {
+	channels := s.channels
+	frameSize := 2 * channels
+	inCount := len(frames) / frameSize
+
+	for i := 0; i < inCount; i++ {
+		for ch := 0; ch < channels; ch++ {
+			off := i*frameSize + ch*2
+			sample := float64(int16(binary.LittleEndian.Uint16(frames[off:])))
+			s.buffer[ch] = append(s.buffer[ch], sample)
+		}
+	}
+
+	var out []byte
+	for {
+		center := int(math.Floor(s.pos))
+		if center+s.quality >= len(s.buffer[0]) {
+			break
+		}
+
+		phase := int(math.Floor((s.pos - float64(center)) * float64(s.l)))
+		if phase >= s.l {
+			phase = s.l - 1
+		}
+
+		for ch := 0; ch < channels; ch++ {
+			window := s.buffer[ch][center-s.quality : center+s.quality]
+			y := convolve(s.taps[phase], window)
+
+			b := make([]byte, 2)
+			binary.LittleEndian.PutUint16(b, uint16(clampInt16(y)))
+			out = append(out, b...)
+		}
+
+		s.pos += float64(s.m) / float64(s.l)
+	}
+
+	// Drop history that's fully behind every future filter window, keeping
+	// just enough left context for the next one.
+	if drop := int(math.Floor(s.pos)) - s.quality; drop > 0 {
+		for ch := range s.buffer {
+			s.buffer[ch] = append([]float64(nil), s.buffer[ch][drop:]...)
+		}
+		s.pos -= float64(drop)
+	}
+
+	return out
+}
+
+func convolve(taps, window []float64) float64 {
+	var sum float64
+	for i, t := range taps {
+		sum += t * window[i]
+	}
+	return sum
+}
@@ -0,0 +1,15 @@
+// +build !flac
+
+package audio
+
+import (
+	"fmt"
+	"io"
+)
+
+// NewFLACEncoder requires the "flac" build tag, which links libFLAC via
+// cgo. Without it, FLAC output is unavailable so pure-Go builds (the
+// default) don't need a C toolchain.
+func NewFLACEncoder(w io.Writer) (AudioEncoder, error) {
+	return nil, fmt.Errorf("audio: FLAC output requires building with -tags flac")
+}
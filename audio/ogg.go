@@ -0,0 +1,104 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// oggPageWriter serializes Ogg pages to w. It implements just enough of
+// RFC 3533 to carry a single logical Opus bitstream: sequential page/packet
+// numbering and the CRC-32 over each page using Ogg's own (non-reflected,
+// 0x04c11db7) polynomial, which is not the IEEE CRC-32 used by zip/gzip.
+type oggPageWriter struct {
+	w            io.Writer
+	serial       uint32
+	pageSequence uint32
+}
+
+func newOggPageWriter(w io.Writer, serial uint32) *oggPageWriter {
+	return &oggPageWriter{w: w, serial: serial}
+}
+
+// writePacket wraps a single Opus packet in its own Ogg page. granulePos is
+// the cumulative sample count (at 48kHz) once this packet's audio has been
+// decoded, as required by the Opus-in-Ogg mapping.
+func (o *oggPageWriter) writePacket(packet []byte, granulePos uint64, headerType byte) (err error) {
+	page := buildOggPage(o.serial, o.pageSequence, headerType, granulePos, packet)
+	o.pageSequence++
+	_, err = o.w.Write(page)
+	return
+}
+
+// buildOggPage serializes packet into a single Ogg page, including framing
+// and a freshly computed checksum. Unlike writePacket it does no I/O and
+// advances no state, so callers that need to reconstruct a page identical to
+// one oggPageWriter already wrote (e.g. to patch it in place without leaving
+// a stale checksum behind) can call it directly with the same arguments.
+func buildOggPage(serial, pageSequence uint32, headerType byte, granulePos uint64, packet []byte) []byte {
+	var segments []byte
+	for remaining := len(packet); ; {
+		if remaining < 255 {
+			segments = append(segments, byte(remaining))
+			break
+		}
+		segments = append(segments, 255)
+		remaining -= 255
+	}
+
+	page := make([]byte, 0, 27+len(segments)+len(packet))
+	page = append(page, []byte("OggS")...)
+	page = append(page, 0) // stream_structure_version
+	page = append(page, headerType)
+
+	granule := make([]byte, 8)
+	binary.LittleEndian.PutUint64(granule, granulePos)
+	page = append(page, granule...)
+
+	serialBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(serialBytes, serial)
+	page = append(page, serialBytes...)
+
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, pageSequence)
+	page = append(page, seq...)
+
+	page = append(page, 0, 0, 0, 0) // checksum placeholder, patched below
+	page = append(page, byte(len(segments)))
+	page = append(page, segments...)
+	page = append(page, packet...)
+
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC32(page))
+	return page
+}
+
+const oggCRCPolynomial = 0x04c11db7
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for bit := 0; bit < 8; bit++ {
+			if r&0x80000000 != 0 {
+				r = (r << 1) ^ oggCRCPolynomial
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return
+}()
+
+func oggCRC32(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = (crc << 8) ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+const (
+	oggHeaderTypeFresh    = 0x00
+	oggHeaderTypeContinue = 0x01
+	oggHeaderTypeBOS      = 0x02
+	oggHeaderTypeEOS      = 0x04
+)
@@ -0,0 +1,246 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// opusOutputRate is the sample rate libopus expects on its own buffers; the
+// Opus-in-Ogg mapping always reports granule positions at 48kHz regardless
+// of the input rate.
+const opusOutputRate = 48000
+
+// opusFrameSizeMs is the Opus frame duration used for every packet. 20ms is
+// the common default trading latency for overhead.
+const opusFrameSizeMs = 20
+
+// OpusSupportedSampleRates lists the input sample rates libopus accepts
+// (RFC 6716 section 2.1.2). OggOpusEncoder doesn't resample on its own, so
+// callers must land the captured format on one of these before calling
+// WriteHeader.
+var OpusSupportedSampleRates = [...]uint32{8000, 12000, 16000, 24000, 48000}
+
+// OpusRequiredSampleRate is the rate callers should resample to when they
+// don't otherwise care which of OpusSupportedSampleRates they land on; it
+// matches opusOutputRate, the rate the Opus-in-Ogg granule position mapping
+// always uses, so resampling and encoding agree on one rate.
+const OpusRequiredSampleRate = opusOutputRate
+
+// IsSupportedOpusSampleRate reports whether rate is one of the fixed set
+// libopus accepts.
+func IsSupportedOpusSampleRate(rate uint32) bool {
+	for _, r := range OpusSupportedSampleRates {
+		if rate == r {
+			return true
+		}
+	}
+	return false
+}
+
+// opusFrameEncoder compresses fixed-size PCM frames into Opus packets. The
+// concrete implementation is selected by the "opus" build tag.
+type opusFrameEncoder interface {
+	encode(pcm []int16) ([]byte, error)
+	// lookaheadSamples reports the encoder's algorithmic delay, in samples
+	// at the rate newOpusFrameEncoder was given, as used for OpusHead's
+	// pre-skip field.
+	lookaheadSamples() int
+	close()
+}
+
+// OggOpusEncoder writes PCM frames as an Ogg-contained Opus stream
+// (RFC 7845). Encoding the Opus payload itself requires the "opus" build
+// tag; without it WriteHeader returns an error so pure-Go builds (the
+// default) still compile.
+type OggOpusEncoder struct {
+	ogg        *oggPageWriter
+	enc        opusFrameEncoder
+	format     Format
+	frameSize  int // samples per channel per Opus frame
+	pending    []byte
+	granule    uint64
+	headerSent bool
+}
+
+// oggOpusSerial is the Ogg stream serial number OggOpusEncoder uses. It
+// never writes more than one logical bitstream, so a fixed value is fine.
+const oggOpusSerial = 1
+
+// NewOggOpusEncoder returns an AudioEncoder that writes an Ogg/Opus stream
+// to w.
+func NewOggOpusEncoder(w io.Writer) (AudioEncoder, error) {
+	return &OggOpusEncoder{ogg: newOggPageWriter(w, oggOpusSerial)}, nil
+}
+
+func (e *OggOpusEncoder) WriteHeader(format Format) (err error) {
+	e.format = format
+	e.frameSize = int(format.SamplesPerSec) * opusFrameSizeMs / 1000
+
+	if e.enc, err = newOpusFrameEncoder(int(format.SamplesPerSec), int(format.Channels)); err != nil {
+		return
+	}
+
+	// RFC 7845 section 4.2: pre-skip must carry the encoder's algorithmic
+	// lookahead, scaled to the 48kHz granule position clock, so a decoder
+	// can trim the leading delay libopus introduces. The granule position
+	// timeline starts counting from that many samples in too, since the
+	// decoder output up to pre-skip is discarded rather than being real
+	// audio at position zero.
+	preSkip := uint16(e.enc.lookaheadSamples() * opusOutputRate / int(format.SamplesPerSec))
+	e.granule = uint64(preSkip)
+
+	if err = e.ogg.writePacket(opusIDHeader(format, preSkip), 0, oggHeaderTypeBOS); err != nil {
+		return
+	}
+	if err = e.ogg.writePacket(opusCommentHeader(), 0, oggHeaderTypeFresh); err != nil {
+		return
+	}
+	e.headerSent = true
+	return
+}
+
+func (e *OggOpusEncoder) WriteFrames(data []byte) (err error) {
+	e.pending = append(e.pending, data...)
+
+	bytesPerFrame := e.frameSize * int(e.format.Channels) * 2
+	for len(e.pending) >= bytesPerFrame {
+		if err = e.encodeFrame(e.pending[:bytesPerFrame]); err != nil {
+			return
+		}
+		e.pending = e.pending[bytesPerFrame:]
+	}
+	return
+}
+
+func (e *OggOpusEncoder) encodeFrame(frame []byte) (err error) {
+	pcm := make([]int16, len(frame)/2)
+	for i := range pcm {
+		pcm[i] = int16(uint16(frame[i*2]) | uint16(frame[i*2+1])<<8)
+	}
+
+	packet, err := e.enc.encode(pcm)
+	if err != nil {
+		return
+	}
+	e.granule += uint64(e.frameSize) * opusOutputRate / uint64(e.format.SamplesPerSec)
+	return e.ogg.writePacket(packet, e.granule, oggHeaderTypeFresh)
+}
+
+func (e *OggOpusEncoder) Close() (err error) {
+	defer e.enc.close()
+
+	if len(e.pending) > 0 {
+		bytesPerFrame := e.frameSize * int(e.format.Channels) * 2
+		padded := make([]byte, bytesPerFrame)
+		copy(padded, e.pending)
+		if err = e.encodeFrame(padded); err != nil {
+			return
+		}
+		e.pending = nil
+	}
+	return e.ogg.writePacket(nil, e.granule, oggHeaderTypeEOS)
+}
+
+// opusIDHeader builds the mandatory "OpusHead" identification packet
+// described in RFC 7845 section 5.1. preSkip is the encoder's algorithmic
+// lookahead, already scaled to the 48kHz granule position clock.
+func opusIDHeader(format Format, preSkip uint16) []byte {
+	head := make([]byte, 19)
+	copy(head[0:8], "OpusHead")
+	head[8] = 1 // version
+	head[9] = byte(format.Channels)
+	binary.LittleEndian.PutUint16(head[10:12], preSkip)
+	binary.LittleEndian.PutUint32(head[12:16], format.SamplesPerSec) // original input rate, informational only
+	binary.LittleEndian.PutUint16(head[16:18], 0)                    // output gain
+	head[18] = 0                                                     // channel mapping family 0: mono/stereo
+	return head
+}
+
+// opusVendorString identifies this tool in the OpusTags packet's vendor
+// field, as required by RFC 7845 section 5.2.
+const opusVendorString = "ywca"
+
+// replayGainGainKey and replayGainPeakKey are the standard Vorbis comment
+// field names (https://wiki.xiph.org/VorbisComment#Replay_Gain) used to
+// carry the ReplayGain 2.0 values analyzer.WrapEncoder computes.
+const (
+	replayGainGainKey = "REPLAYGAIN_TRACK_GAIN="
+	replayGainPeakKey = "REPLAYGAIN_TRACK_PEAK="
+)
+
+// FormatOpusReplayGainGain renders a track gain value to the fixed 7-byte
+// width (plus " dB" suffix) that opusCommentHeader reserves for it. db is
+// assumed to fit in three integer digits, which every realistic ReplayGain
+// value does.
+func FormatOpusReplayGainGain(db float64) string {
+	return fmt.Sprintf("%+07.2f dB", db)
+}
+
+// FormatOpusReplayGainPeak renders a track peak value to the fixed 8-byte
+// width opusCommentHeader reserves for it. peak is assumed to be a linear
+// sample amplitude below 10, which every realistic true-peak measurement
+// is.
+func FormatOpusReplayGainPeak(peak float64) string {
+	return fmt.Sprintf("%08.6f", peak)
+}
+
+// opusCommentHeader builds the mandatory "OpusTags" comment packet
+// described in RFC 7845 section 5.2. It always reserves two fixed-width
+// user comments for the ReplayGain 2.0 values analyzer.WrapEncoder
+// computes, written here as zero placeholders; RewriteOpusReplayGainPage
+// re-emits this whole page, checksum included, once the real values are
+// known.
+func opusCommentHeader() []byte {
+	return opusCommentPacket(0, 0)
+}
+
+// opusCommentPacket builds the "OpusTags" packet carrying the given
+// ReplayGain values. Every value FormatOpusReplayGainGain/Peak can produce
+// is the same fixed width, so the packet opusCommentHeader reserves at
+// WriteHeader time and the one RewriteOpusReplayGainPage later rebuilds are
+// always identical in length.
+func opusCommentPacket(gainDB, peak float64) []byte {
+	gain := replayGainGainKey + FormatOpusReplayGainGain(gainDB)
+	peakField := replayGainPeakKey + FormatOpusReplayGainPeak(peak)
+
+	buf := make([]byte, 0, 8+4+len(opusVendorString)+4+4+len(gain)+4+len(peakField))
+	buf = append(buf, "OpusTags"...)
+	buf = appendUint32LE(buf, uint32(len(opusVendorString)))
+	buf = append(buf, opusVendorString...)
+	buf = appendUint32LE(buf, 2) // two reserved user comments
+	buf = appendUint32LE(buf, uint32(len(gain)))
+	buf = append(buf, gain...)
+	buf = appendUint32LE(buf, uint32(len(peakField)))
+	buf = append(buf, peakField...)
+	return buf
+}
+
+// opusCommentPageOffset is the byte offset, from the start of an Ogg/Opus
+// stream written by OggOpusEncoder, where the comment (OpusTags) page
+// begins: right after the single-packet OpusHead page.
+const opusCommentPageOffset = 27 + 1 + 19 // page header + 1-byte segment table + opusIDHeader's fixed 19 bytes
+
+// RewriteOpusReplayGainPage overwrites the placeholder comment page
+// OggOpusEncoder wrote during WriteHeader with one carrying the real
+// ReplayGain 2.0 values, recomputing its checksum so the result is a valid
+// Ogg page rather than one whose CRC was only valid for the placeholder
+// content. w must be the same stream OggOpusEncoder wrote, positioned
+// anywhere; RewriteOpusReplayGainPage seeks on its own.
+func RewriteOpusReplayGainPage(w io.WriteSeeker, gainDB, peak float64) error {
+	const commentPageSequence = 1 // writePacket's second call, after the BOS page's 0
+
+	page := buildOggPage(oggOpusSerial, commentPageSequence, oggHeaderTypeFresh, 0, opusCommentPacket(gainDB, peak))
+
+	if _, err := w.Seek(opusCommentPageOffset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := w.Write(page)
+	return err
+}
+
+func appendUint32LE(buf []byte, v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return append(buf, b...)
+}
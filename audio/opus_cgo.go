@@ -0,0 +1,64 @@
+// +build opus
+
+package audio
+
+// #cgo LDFLAGS: -lopus
+// #include <stdlib.h>
+// #include <opus/opus.h>
+//
+// // OPUS_GET_LOOKAHEAD is a variadic-ctl macro, which cgo can't call
+// // directly; wrap it in a plain function with a fixed signature.
+// static int ywca_opus_get_lookahead(OpusEncoder *enc, opus_int32 *lookahead) {
+//     return opus_encoder_ctl(enc, OPUS_GET_LOOKAHEAD(lookahead));
+// }
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// libopusFrameEncoder wraps a C OpusEncoder. It is only built with
+// -tags opus, since it links against libopus via cgo.
+type libopusFrameEncoder struct {
+	enc      *C.OpusEncoder
+	channels int
+}
+
+func newOpusFrameEncoder(sampleRate int, channels int) (opusFrameEncoder, error) {
+	var status C.int
+	enc := C.opus_encoder_create(C.opus_int32(sampleRate), C.int(channels), C.OPUS_APPLICATION_AUDIO, &status)
+	if status != C.OPUS_OK {
+		return nil, fmt.Errorf("audio: opus_encoder_create failed: %d", status)
+	}
+	return &libopusFrameEncoder{enc: enc, channels: channels}, nil
+}
+
+// encode compresses one 20ms frame of interleaved 16-bit PCM into an Opus
+// packet.
+func (e *libopusFrameEncoder) encode(pcm []int16) (packet []byte, err error) {
+	out := make([]byte, 4000) // worst case per RFC 6716
+	n := C.opus_encode(
+		e.enc,
+		(*C.opus_int16)(unsafe.Pointer(&pcm[0])),
+		C.int(len(pcm)/e.channels), // samples per channel
+		(*C.uchar)(unsafe.Pointer(&out[0])),
+		C.opus_int32(len(out)),
+	)
+	if n < 0 {
+		return nil, fmt.Errorf("audio: opus_encode failed: %d", n)
+	}
+	return out[:n], nil
+}
+
+// lookaheadSamples reports libopus's encoder lookahead (algorithmic delay),
+// in samples at the encoder's own sample rate, via OPUS_GET_LOOKAHEAD.
+func (e *libopusFrameEncoder) lookaheadSamples() int {
+	var lookahead C.opus_int32
+	C.ywca_opus_get_lookahead(e.enc, &lookahead)
+	return int(lookahead)
+}
+
+func (e *libopusFrameEncoder) close() {
+	C.opus_encoder_destroy(e.enc)
+}
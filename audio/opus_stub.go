@@ -0,0 +1,12 @@
+// +build !opus
+
+package audio
+
+import "fmt"
+
+// newOpusFrameEncoder requires the "opus" build tag, which links libopus
+// via cgo. Without it, Opus/Ogg output is unavailable so pure-Go builds
+// (the default) don't need a C toolchain.
+func newOpusFrameEncoder(sampleRate int, channels int) (opusFrameEncoder, error) {
+	return nil, fmt.Errorf("audio: Opus output requires building with -tags opus")
+}
@@ -0,0 +1,63 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// wavHeaderSize is the size in bytes of the canonical 16-byte-fmt WAVE
+// header written by WAVEncoder.
+const wavHeaderSize = 44
+
+// WAVEncoder writes PCM frames into a RIFF/WAVE file as they arrive. Since
+// the RIFF and data chunk sizes are only known once capturing stops, it
+// writes a placeholder header up front and seeks back to patch it in Close.
+type WAVEncoder struct {
+	w        io.WriteSeeker
+	format   Format
+	dataSize uint32
+}
+
+// NewWAVEncoder returns an AudioEncoder that writes a standard PCM WAVE file
+// to w.
+func NewWAVEncoder(w io.WriteSeeker) *WAVEncoder {
+	return &WAVEncoder{w: w}
+}
+
+func (e *WAVEncoder) WriteHeader(format Format) (err error) {
+	e.format = format
+	_, err = e.w.Write(make([]byte, wavHeaderSize))
+	return
+}
+
+func (e *WAVEncoder) WriteFrames(data []byte) (err error) {
+	if _, err = e.w.Write(data); err != nil {
+		return
+	}
+	e.dataSize += uint32(len(data))
+	return
+}
+
+func (e *WAVEncoder) Close() (err error) {
+	if _, err = e.w.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, []byte("RIFF"))
+	binary.Write(buf, binary.LittleEndian, uint32(e.dataSize+36)) // Header size is 44 byte, so 44 - 8 = 36
+	binary.Write(buf, binary.BigEndian, []byte("WAVEfmt "))
+	binary.Write(buf, binary.LittleEndian, uint32(16)) // 16 (0x10000000) for PCM
+	binary.Write(buf, binary.LittleEndian, uint16(1))  // 1 (0x0001) for PCM
+	binary.Write(buf, binary.LittleEndian, e.format.Channels)
+	binary.Write(buf, binary.LittleEndian, e.format.SamplesPerSec)
+	binary.Write(buf, binary.LittleEndian, e.format.AvgBytesPerSec)
+	binary.Write(buf, binary.LittleEndian, e.format.BlockAlign)
+	binary.Write(buf, binary.LittleEndian, e.format.BitsPerSample)
+	binary.Write(buf, binary.BigEndian, []byte("data"))
+	binary.Write(buf, binary.LittleEndian, e.dataSize)
+
+	_, err = e.w.Write(buf.Bytes())
+	return
+}
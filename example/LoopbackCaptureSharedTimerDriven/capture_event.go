@@ -0,0 +1,293 @@
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca"
+	"github.com/moutend/ywca/audio"
+)
+
+const (
+	eventAllAccess  = 0x1F0003
+	infiniteTimeout = 0xFFFFFFFF
+	waitObject0     = 0x00000000
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEventExW      = kernel32.NewProc("CreateEventExW")
+	procWaitForSingleObject = kernel32.NewProc("WaitForSingleObject")
+	procCloseHandle         = kernel32.NewProc("CloseHandle")
+)
+
+func createEventEx() (handle syscall.Handle, err error) {
+	r, _, e := procCreateEventExW.Call(0, 0, 0, eventAllAccess)
+	if r == 0 {
+		err = e
+		return
+	}
+	handle = syscall.Handle(r)
+	return
+}
+
+func waitForSingleObject(handle syscall.Handle, timeoutMs uint32) (err error) {
+	r, _, e := procWaitForSingleObject.Call(uintptr(handle), uintptr(timeoutMs))
+	if r != waitObject0 {
+		err = e
+	}
+	return
+}
+
+// loopbackCaptureSharedEventDriven captures loopback audio the same way as
+// loopbackCaptureSharedTimerDriven, but instead of polling with time.Sleep it
+// blocks on a Win32 event signaled by WASAPI whenever a new buffer is ready.
+// Loopback streams never signal their own event, so a silent render client is
+// activated on the same endpoint to keep the shared clock (and therefore the
+// event) ticking, the same trick OBS uses in place of a stereo-mix device.
+func loopbackCaptureSharedEventDriven(duration time.Duration, enc audio.AudioEncoder, mmd *wca.IMMDevice) (err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err = ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+		return
+	}
+	defer ole.CoUninitialize()
+
+	var ps *wca.IPropertyStore
+	if err = mmd.OpenPropertyStore(wca.STGM_READ, &ps); err != nil {
+		return
+	}
+	defer ps.Release()
+
+	var pv wca.PROPVARIANT
+	if err = ps.GetValue(&wca.PKEY_Device_FriendlyName, &pv); err != nil {
+		return
+	}
+	fmt.Printf("Capturing what you hear from: %s\n", pv.String())
+
+	var ac *wca.IAudioClient
+	if err = mmd.Activate(wca.IID_IAudioClient, wca.CLSCTX_ALL, nil, &ac); err != nil {
+		return
+	}
+	defer ac.Release()
+
+	var wfx *wca.WAVEFORMATEX
+	if err = ac.GetMixFormat(&wfx); err != nil {
+		return
+	}
+	defer ole.CoTaskMemFree(uintptr(unsafe.Pointer(wfx)))
+
+	wfx.WFormatTag = 1
+	wfx.NChannels = 2
+	wfx.NSamplesPerSec = 44100
+	wfx.WBitsPerSample = 16
+	wfx.NBlockAlign = (wfx.WBitsPerSample / 8) * wfx.NChannels
+	wfx.NAvgBytesPerSec = wfx.NSamplesPerSec * uint32(wfx.NBlockAlign)
+	wfx.CbSize = 0
+
+	format := audio.Format{
+		Channels:       wfx.NChannels,
+		SamplesPerSec:  wfx.NSamplesPerSec,
+		AvgBytesPerSec: wfx.NAvgBytesPerSec,
+		BlockAlign:     wfx.NBlockAlign,
+		BitsPerSample:  wfx.WBitsPerSample,
+	}
+	if err = enc.WriteHeader(format); err != nil {
+		return
+	}
+
+	fmt.Println("--------")
+	fmt.Printf("Format: PCM %d bit signed integer\n", wfx.WBitsPerSample)
+	fmt.Printf("Rate: %d Hz\n", wfx.NSamplesPerSec)
+	fmt.Printf("Channels: %d\n", wfx.NChannels)
+	fmt.Println("--------")
+
+	if err = ac.Initialize(wca.AUDCLNT_SHAREMODE_SHARED, wca.AUDCLNT_STREAMFLAGS_EVENTCALLBACK|wca.AUDCLNT_STREAMFLAGS_LOOPBACK, 200*10000, 0, wfx, nil); err != nil {
+		return
+	}
+
+	audioEvent, err := createEventEx()
+	if err != nil {
+		return
+	}
+	defer procCloseHandle.Call(uintptr(audioEvent))
+
+	if err = ac.SetEventHandle(uintptr(audioEvent)); err != nil {
+		return
+	}
+
+	var bufferFrameSize uint32
+	if err = ac.GetBufferSize(&bufferFrameSize); err != nil {
+		return
+	}
+	fmt.Printf("Allocated buffer size: %d\n", bufferFrameSize)
+
+	var acc *wca.IAudioCaptureClient
+	if err = ac.GetService(wca.IID_IAudioCaptureClient, &acc); err != nil {
+		return
+	}
+	defer acc.Release()
+
+	renderStop := make(chan struct{})
+	renderDone := make(chan error, 1)
+	go driveSilentRenderClient(mmd, wfx, renderStop, renderDone)
+	defer func() {
+		close(renderStop)
+		<-renderDone
+	}()
+
+	if err = ac.Start(); err != nil {
+		return
+	}
+	fmt.Println("Start capturing loopback audio with shared-event-driven mode")
+	if duration <= 0 {
+		fmt.Println("Press Ctrl-C to stop capturing")
+	}
+
+	var isCapturing bool = true
+	var currentDuration time.Duration
+	var dataSize uint32
+	var data *byte
+	var b *byte
+	var availableFrameSize uint32
+	var flags uint32
+	var devicePosition uint64
+	var qcpPosition uint64
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+
+	for isCapturing {
+		select {
+		case <-signalChan:
+			fmt.Println("Interrupted by SIGINT")
+			isCapturing = false
+			continue
+		default:
+		}
+
+		currentDuration = time.Duration(float64(dataSize) / float64(format.BitsPerSample/8) / float64(format.Channels) / float64(format.SamplesPerSec) * float64(time.Second))
+		if duration != 0 && currentDuration > duration {
+			isCapturing = false
+			continue
+		}
+		if err = waitForSingleObject(audioEvent, 2000); err != nil {
+			return
+		}
+		if err = acc.GetBuffer(&data, &availableFrameSize, &flags, &devicePosition, &qcpPosition); err != nil {
+			return
+		}
+		if availableFrameSize == 0 {
+			if err = acc.ReleaseBuffer(availableFrameSize); err != nil {
+				return
+			}
+			continue
+		}
+
+		start := unsafe.Pointer(data)
+		lim := int(availableFrameSize) * int(wfx.NBlockAlign)
+		frame := make([]byte, lim)
+
+		for n := 0; n < lim; n++ {
+			b = (*byte)(unsafe.Pointer(uintptr(start) + uintptr(n)))
+			frame[n] = *b
+		}
+		if err = enc.WriteFrames(frame); err != nil {
+			return
+		}
+		dataSize += uint32(lim)
+		if err = acc.ReleaseBuffer(availableFrameSize); err != nil {
+			return
+		}
+	}
+
+	fmt.Println("Stop capturing")
+	if err = ac.Stop(); err != nil {
+		return
+	}
+	return
+}
+
+// driveSilentRenderClient keeps a shared-mode render stream on the same
+// endpoint running so the audio engine's clock advances even when nothing is
+// playing, since a loopback-only stream never signals its own event.
+func driveSilentRenderClient(mmd *wca.IMMDevice, wfx *wca.WAVEFORMATEX, stop <-chan struct{}, done chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var err error
+	defer func() { done <- err }()
+
+	if err = ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+		return
+	}
+	defer ole.CoUninitialize()
+
+	var ac *wca.IAudioClient
+	if err = mmd.Activate(wca.IID_IAudioClient, wca.CLSCTX_ALL, nil, &ac); err != nil {
+		return
+	}
+	defer ac.Release()
+
+	if err = ac.Initialize(wca.AUDCLNT_SHAREMODE_SHARED, 0, 200*10000, 0, wfx, nil); err != nil {
+		return
+	}
+
+	var bufferFrameSize uint32
+	if err = ac.GetBufferSize(&bufferFrameSize); err != nil {
+		return
+	}
+
+	var arc *wca.IAudioRenderClient
+	if err = ac.GetService(wca.IID_IAudioRenderClient, &arc); err != nil {
+		return
+	}
+	defer arc.Release()
+
+	var data *byte
+	if err = arc.GetBuffer(bufferFrameSize, &data); err != nil {
+		return
+	}
+	if err = arc.ReleaseBuffer(bufferFrameSize, wca.AUDCLNT_BUFFERFLAGS_SILENT); err != nil {
+		return
+	}
+
+	if err = ac.Start(); err != nil {
+		return
+	}
+	defer ac.Stop()
+
+	period := time.Duration(float64(bufferFrameSize) / float64(wfx.NSamplesPerSec) * float64(time.Second) / 2)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(period):
+		}
+
+		var padding uint32
+		if err = ac.GetCurrentPadding(&padding); err != nil {
+			return
+		}
+		available := bufferFrameSize - padding
+		if available == 0 {
+			continue
+		}
+		if err = arc.GetBuffer(available, &data); err != nil {
+			return
+		}
+		if err = arc.ReleaseBuffer(available, wca.AUDCLNT_BUFFERFLAGS_SILENT); err != nil {
+			return
+		}
+	}
+}
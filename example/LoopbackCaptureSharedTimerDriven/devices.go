@@ -0,0 +1,141 @@
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca"
+)
+
+// audioDevice is the subset of an IMMDevice's properties needed to let a
+// user pick a render endpoint from the command line.
+type audioDevice struct {
+	ID           string
+	FriendlyName string
+}
+
+// enumerateRenderDevices lists the active render endpoints (the ones that
+// can be loopback-captured), such as speakers, headphones or an HDMI
+// output.
+func enumerateRenderDevices() (devices []audioDevice, err error) {
+	if err = ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+		return
+	}
+
+	var de *wca.IMMDeviceEnumerator
+	if err = wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &de); err != nil {
+		return
+	}
+	defer de.Release()
+
+	var collection *wca.IMMDeviceCollection
+	if err = de.EnumAudioEndpoints(wca.ERender, wca.DEVICE_STATE_ACTIVE, &collection); err != nil {
+		return
+	}
+	defer collection.Release()
+
+	var count uint32
+	if err = collection.GetCount(&count); err != nil {
+		return
+	}
+
+	for i := uint32(0); i < count; i++ {
+		var mmd *wca.IMMDevice
+		if err = collection.Item(i, &mmd); err != nil {
+			return
+		}
+
+		var device audioDevice
+		if device, err = describeDevice(mmd); err != nil {
+			mmd.Release()
+			return
+		}
+		mmd.Release()
+
+		devices = append(devices, device)
+	}
+	return
+}
+
+func describeDevice(mmd *wca.IMMDevice) (device audioDevice, err error) {
+	if err = mmd.GetId(&device.ID); err != nil {
+		return
+	}
+
+	var ps *wca.IPropertyStore
+	if err = mmd.OpenPropertyStore(wca.STGM_READ, &ps); err != nil {
+		return
+	}
+	defer ps.Release()
+
+	var pv wca.PROPVARIANT
+	if err = ps.GetValue(&wca.PKEY_Device_FriendlyName, &pv); err != nil {
+		return
+	}
+	device.FriendlyName = pv.String()
+	return
+}
+
+// printDevices writes a human-readable device list for --list-devices.
+func printDevices(devices []audioDevice) {
+	for _, device := range devices {
+		fmt.Printf("%s\t%s\n", device.ID, device.FriendlyName)
+	}
+}
+
+// resolveDevice activates the IMMDevice matching id (compared against both
+// the endpoint ID and the friendly name so "speakers" and the raw ID both
+// work), or falls back to the default render endpoint when id is empty.
+func resolveDevice(id string) (mmd *wca.IMMDevice, err error) {
+	if err = ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+		return
+	}
+
+	var de *wca.IMMDeviceEnumerator
+	if err = wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &de); err != nil {
+		return
+	}
+	defer de.Release()
+
+	if id == "" {
+		err = de.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &mmd)
+		return
+	}
+
+	var collection *wca.IMMDeviceCollection
+	if err = de.EnumAudioEndpoints(wca.ERender, wca.DEVICE_STATE_ACTIVE, &collection); err != nil {
+		return
+	}
+	defer collection.Release()
+
+	var count uint32
+	if err = collection.GetCount(&count); err != nil {
+		return
+	}
+
+	for i := uint32(0); i < count; i++ {
+		var candidate *wca.IMMDevice
+		if err = collection.Item(i, &candidate); err != nil {
+			return
+		}
+
+		var device audioDevice
+		if device, err = describeDevice(candidate); err != nil {
+			candidate.Release()
+			return
+		}
+
+		if device.ID == id || strings.EqualFold(device.FriendlyName, id) {
+			mmd = candidate
+			err = nil
+			return
+		}
+		candidate.Release()
+	}
+
+	err = fmt.Errorf("no render device matching %q", id)
+	return
+}
@@ -1,12 +1,10 @@
 // +build windows
+
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
@@ -17,39 +15,11 @@ import (
 
 	"github.com/go-ole/go-ole"
 	"github.com/moutend/go-wca"
+	"github.com/moutend/ywca/analyzer"
+	"github.com/moutend/ywca/audio"
+	"github.com/moutend/ywca/audio/filter"
 )
 
-type WAVEFormat struct {
-	FormatTag      uint16
-	Channels       uint16
-	SamplesPerSec  uint32
-	AvgBytesPerSec uint32
-	BlockAlign     uint16
-	BitsPerSample  uint16
-	DataSize       uint32
-	RawData        []byte
-}
-
-func (v *WAVEFormat) Bytes() (output []byte) {
-	buf := new(bytes.Buffer)
-
-	binary.Write(buf, binary.BigEndian, []byte("RIFF"))
-	binary.Write(buf, binary.LittleEndian, uint32(v.DataSize+36)) // Header size is 44 byte, so 44 - 8 = 36
-	binary.Write(buf, binary.BigEndian, []byte("WAVEfmt "))
-	binary.Write(buf, binary.LittleEndian, uint32(16)) // 16 (0x10000000) for PCM
-	binary.Write(buf, binary.LittleEndian, uint16(1))  // 1 (0x0001) for PCM
-	binary.Write(buf, binary.LittleEndian, v.Channels)
-	binary.Write(buf, binary.LittleEndian, v.SamplesPerSec)
-	binary.Write(buf, binary.LittleEndian, v.AvgBytesPerSec)
-	binary.Write(buf, binary.LittleEndian, v.BlockAlign)
-	binary.Write(buf, binary.LittleEndian, v.BitsPerSample)
-	binary.Write(buf, binary.BigEndian, []byte("data"))
-	binary.Write(buf, binary.LittleEndian, v.DataSize)
-	binary.Write(buf, binary.LittleEndian, v.RawData)
-
-	return buf.Bytes()
-}
-
 type DurationFlag struct {
 	Value time.Duration
 }
@@ -73,8 +43,12 @@ type FilenameFlag struct {
 }
 
 func (f *FilenameFlag) Set(value string) (err error) {
-	if !strings.HasSuffix(value, ".wav") {
-		err = fmt.Errorf("specify WAVE audio file (*.wav)")
+	switch {
+	case strings.HasSuffix(value, ".wav"):
+	case strings.HasSuffix(value, ".flac"):
+	case strings.HasSuffix(value, ".opus"), strings.HasSuffix(value, ".ogg"):
+	default:
+		err = fmt.Errorf("specify WAVE, FLAC or Ogg/Opus audio file (*.wav, *.flac, *.opus or *.ogg)")
 		return
 	}
 	f.Value = value
@@ -85,6 +59,43 @@ func (f *FilenameFlag) String() string {
 	return f.Value
 }
 
+type ModeFlag struct {
+	Value string
+}
+
+func (f *ModeFlag) Set(value string) (err error) {
+	switch value {
+	case "timer", "event":
+		f.Value = value
+	default:
+		err = fmt.Errorf("specify capturing mode (timer or event)")
+	}
+	return
+}
+
+func (f *ModeFlag) String() string {
+	return f.Value
+}
+
+type GainFlag struct {
+	Value    float64
+	HasValue bool
+}
+
+func (f *GainFlag) Set(value string) (err error) {
+	var db float64
+	if db, err = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSuffix(value, "dB"), "db"), 64); err != nil {
+		return
+	}
+	f.Value = db
+	f.HasValue = true
+	return
+}
+
+func (f *GainFlag) String() string {
+	return fmt.Sprintf("%gdB", f.Value)
+}
+
 func main() {
 	var err error
 	if err = run(os.Args); err != nil {
@@ -95,44 +106,129 @@ func main() {
 func run(args []string) (err error) {
 	var durationFlag DurationFlag
 	var filenameFlag FilenameFlag
-	var audio *WAVEFormat
+	var modeFlag ModeFlag = ModeFlag{Value: "timer"}
+	var listDevicesFlag bool
+	var deviceFlag string
+	var pidFlag uint
+	var rateFlag uint
+	var channelsFlag uint
+	var gainFlag GainFlag
+	var highPassFlag float64
+	var resampleQualityFlag uint
+	var analyzeFlag bool
+	var tagFlag bool
 
 	f := flag.NewFlagSet(args[0], flag.ExitOnError)
 	f.Var(&durationFlag, "duration", "Specify recording duration in second")
 	f.Var(&durationFlag, "d", "Alias of --duration")
 	f.Var(&filenameFlag, "output", "file name")
 	f.Var(&filenameFlag, "o", "Alias of --output")
+	f.Var(&modeFlag, "mode", "Specify capturing mode: timer or event")
+	f.BoolVar(&listDevicesFlag, "list-devices", false, "List active render endpoints and exit")
+	f.StringVar(&deviceFlag, "device", "", "Specify render device by ID or friendly name")
+	f.UintVar(&pidFlag, "pid", 0, "Capture audio from this process (and its children) only, instead of a whole endpoint")
+	f.UintVar(&rateFlag, "rate", 0, "Resample output to this rate in Hz, e.g. 16000")
+	f.UintVar(&channelsFlag, "channels", 0, "Downmix output to this many channels, e.g. 1 for mono")
+	f.Var(&gainFlag, "gain", "Apply gain to the output, e.g. -3dB")
+	f.Float64Var(&highPassFlag, "highpass", 0, "Apply a high-pass filter with this cutoff frequency in Hz, e.g. 80")
+	f.UintVar(&resampleQualityFlag, "resample-quality", 0, "Taps per side per phase for --rate resampling; 0 uses the default")
+	f.BoolVar(&analyzeFlag, "analyze", false, "Report integrated loudness, true peak and ReplayGain 2.0 values after capturing")
+	f.BoolVar(&tagFlag, "tag", false, "Requires --analyze; write the computed ReplayGain values into the Ogg/Opus comment header of the output file (WAV and FLAC output is not tagged)")
 	f.Parse(args[1:])
 
+	if listDevicesFlag {
+		var devices []audioDevice
+		if devices, err = enumerateRenderDevices(); err != nil {
+			return
+		}
+		printDevices(devices)
+		return
+	}
+
 	if filenameFlag.Value == "" {
 		return
 	}
-	if audio, err = loopbackCaptureSharedTimerDriven(durationFlag.Value); err != nil {
+
+	var mmd *wca.IMMDevice
+	if mmd, err = resolveDevice(deviceFlag); err != nil {
 		return
 	}
-	if err = ioutil.WriteFile(filenameFlag.Value, audio.Bytes(), 0644); err != nil {
+	defer mmd.Release()
+
+	var file *os.File
+	if file, err = os.Create(filenameFlag.Value); err != nil {
 		return
 	}
-	fmt.Println("Successfully done")
-	return
-}
+	defer file.Close()
 
-func loopbackCaptureSharedTimerDriven(duration time.Duration) (audio *WAVEFormat, err error) {
-	if err = ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+	var enc audio.AudioEncoder
+	if enc, err = audio.NewEncoder(filenameFlag.Value, file); err != nil {
 		return
 	}
 
-	var de *wca.IMMDeviceEnumerator
-	if err = wca.CoCreateInstance(wca.CLSID_MMDeviceEnumerator, 0, wca.CLSCTX_ALL, wca.IID_IMMDeviceEnumerator, &de); err != nil {
+	lowerFilename := strings.ToLower(filenameFlag.Value)
+	if strings.HasSuffix(lowerFilename, ".opus") || strings.HasSuffix(lowerFilename, ".ogg") {
+		switch {
+		case rateFlag == 0:
+			// Capture is hard-coded to 44.1kHz, which libopus doesn't accept;
+			// resample to the rate its encoder is built against.
+			rateFlag = audio.OpusRequiredSampleRate
+		case !audio.IsSupportedOpusSampleRate(uint32(rateFlag)):
+			err = fmt.Errorf("--rate %d is not supported for Ogg/Opus output; libopus requires one of %v", rateFlag, audio.OpusSupportedSampleRates)
+			return
+		}
+	}
+
+	if rateFlag != 0 || channelsFlag != 0 || gainFlag.HasValue || highPassFlag != 0 {
+		enc = filter.WrapEncoder(enc, filter.Spec{
+			TargetRate:      uint32(rateFlag),
+			TargetChannels:  uint16(channelsFlag),
+			GainDB:          gainFlag.Value,
+			HighPassHz:      highPassFlag,
+			ResampleQuality: int(resampleQualityFlag),
+		})
+	}
+
+	if analyzeFlag {
+		enc = analyzer.WrapEncoder(enc, file, filenameFlag.Value, tagFlag)
+	}
+
+	captured := false
+	if pidFlag != 0 {
+		var started bool
+		if started, err = loopbackCaptureProcess(durationFlag.Value, enc, uint32(pidFlag)); err != nil {
+			if started {
+				return
+			}
+			fmt.Printf("Per-process loopback capture unavailable (%v), falling back to whole-endpoint capture\n", err)
+			err = nil
+		} else {
+			captured = true
+		}
+	}
+
+	if !captured {
+		switch modeFlag.Value {
+		case "event":
+			err = loopbackCaptureSharedEventDriven(durationFlag.Value, enc, mmd)
+		default:
+			err = loopbackCaptureSharedTimerDriven(durationFlag.Value, enc, mmd)
+		}
+	}
+	if err != nil {
+		return
+	}
+	if err = enc.Close(); err != nil {
 		return
 	}
-	defer de.Release()
+	fmt.Println("Successfully done")
+	return
+}
 
-	var mmd *wca.IMMDevice
-	if err = de.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &mmd); err != nil {
+func loopbackCaptureSharedTimerDriven(duration time.Duration, enc audio.AudioEncoder, mmd *wca.IMMDevice) (err error) {
+	if err = ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
 		return
 	}
-	defer mmd.Release()
 
 	var ps *wca.IPropertyStore
 	if err = mmd.OpenPropertyStore(wca.STGM_READ, &ps); err != nil {
@@ -166,12 +262,16 @@ func loopbackCaptureSharedTimerDriven(duration time.Duration) (audio *WAVEFormat
 	wfx.NAvgBytesPerSec = wfx.NSamplesPerSec * uint32(wfx.NBlockAlign)
 	wfx.CbSize = 0
 
-	audio = &WAVEFormat{}
-	audio.Channels = wfx.NChannels
-	audio.SamplesPerSec = wfx.NSamplesPerSec
-	audio.AvgBytesPerSec = wfx.NAvgBytesPerSec
-	audio.BlockAlign = wfx.NBlockAlign
-	audio.BitsPerSample = wfx.WBitsPerSample
+	format := audio.Format{
+		Channels:       wfx.NChannels,
+		SamplesPerSec:  wfx.NSamplesPerSec,
+		AvgBytesPerSec: wfx.NAvgBytesPerSec,
+		BlockAlign:     wfx.NBlockAlign,
+		BitsPerSample:  wfx.WBitsPerSample,
+	}
+	if err = enc.WriteHeader(format); err != nil {
+		return
+	}
 
 	fmt.Println("--------")
 	fmt.Printf("Format: PCM %d bit signed integer\n", wfx.WBitsPerSample)
@@ -215,6 +315,7 @@ func loopbackCaptureSharedTimerDriven(duration time.Duration) (audio *WAVEFormat
 
 	var isCapturing bool = true
 	var currentDuration time.Duration
+	var dataSize uint32
 	var data *byte
 	var b *byte
 	var availableFrameSize uint32
@@ -236,7 +337,7 @@ func loopbackCaptureSharedTimerDriven(duration time.Duration) (audio *WAVEFormat
 			isCapturing = false
 			break
 		default:
-			currentDuration = time.Duration(float64(audio.DataSize) / float64(audio.BitsPerSample/8) / float64(audio.Channels) / float64(audio.SamplesPerSec) * float64(time.Second))
+			currentDuration = time.Duration(float64(dataSize) / float64(format.BitsPerSample/8) / float64(format.Channels) / float64(format.SamplesPerSec) * float64(time.Second))
 			if duration != 0 && currentDuration > duration {
 				isCapturing = false
 				break
@@ -250,12 +351,16 @@ func loopbackCaptureSharedTimerDriven(duration time.Duration) (audio *WAVEFormat
 
 			start := unsafe.Pointer(data)
 			lim := int(availableFrameSize) * int(wfx.NBlockAlign)
+			frame := make([]byte, lim)
 
 			for n := 0; n < lim; n++ {
 				b = (*byte)(unsafe.Pointer(uintptr(start) + uintptr(n)))
-				audio.RawData = append(audio.RawData, *b)
+				frame[n] = *b
+			}
+			if err = enc.WriteFrames(frame); err != nil {
+				return
 			}
-			audio.DataSize += uint32(lim)
+			dataSize += uint32(lim)
 			if err = ac.GetCurrentPadding(&padding); err != nil {
 				return
 			}